@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withCacheDir points cacheDir-based helpers at a scratch directory for the
+// duration of the test, cleaning up afterwards.
+func withCacheDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+func TestHashParamsIsStableAndDistinguishesInputs(t *testing.T) {
+	base := APIRequest{Zoom: 256, Bits: 16}
+
+	if hashParams(base) != hashParams(base) {
+		t.Fatal("hashParams is not stable for identical input")
+	}
+
+	variants := []APIRequest{
+		{Zoom: 512, Bits: 16},                              // different zoom
+		{Zoom: 256, Bits: 8},                               // different bits
+		{Zoom: 256, Bits: 16, SplitChannels: true},         // different split
+		{Zoom: 256, Bits: 16, AmplitudeScale: 2},           // different amplitude
+		{Zoom: 256, Bits: 16, Engine: engineAudiowaveform}, // different engine
+		{Zoom: 256, Bits: 16, TotalPoints: 1000},           // different total points
+		{Zoom: 256, Bits: 16, PointsPerSecond: 100},        // different points/sec
+	}
+
+	baseHash := hashParams(base)
+	seen := map[string]bool{baseHash: true}
+	for i, v := range variants {
+		h := hashParams(v)
+		if seen[h] {
+			t.Errorf("variant %d collided with a previous hash (%s)", i, h)
+		}
+		seen[h] = true
+	}
+}
+
+func TestHashParamsEngineResolution(t *testing.T) {
+	// Same render params, different resolved engine (explicit field here,
+	// but resolveEngine also consults WAVEFORM_ENGINE) must not collide -
+	// this is the cache-poisoning bug the chunk0-4 review caught.
+	native := APIRequest{Zoom: 256, Engine: engineNative}
+	waveform := APIRequest{Zoom: 256, Engine: engineAudiowaveform}
+	if hashParams(native) == hashParams(waveform) {
+		t.Fatal("hashParams must differ when the resolved engine differs")
+	}
+}
+
+func TestCachedResponseRoundTrip(t *testing.T) {
+	withCacheDir(t)
+
+	resp := WaveformResponse{
+		Metadata:      &Metadata{Duration: 1.5, SampleRate: 44100, Channels: 2},
+		Audiowaveform: map[string]any{"length": 3.0},
+	}
+	storeCachedResponse("audiohash", "paramshash", resp)
+
+	got, ok := loadCachedResponse("audiohash", "paramshash")
+	if !ok {
+		t.Fatal("expected cached response to be found")
+	}
+	if got.Audiowaveform.(map[string]any)["length"] != 3.0 {
+		t.Errorf("round-tripped response mismatch: %+v", got)
+	}
+
+	if _, ok := loadCachedResponse("audiohash", "otherparamshash"); ok {
+		t.Error("expected no cache hit for a different params hash")
+	}
+}
+
+func TestCachedAudioRoundTrip(t *testing.T) {
+	withCacheDir(t)
+
+	srcPath := filepath.Join(t.TempDir(), "in.wav")
+	if err := os.WriteFile(srcPath, []byte("fake-audio-bytes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := Metadata{Duration: 2, SampleRate: 48000, Channels: 1, Container: "wav"}
+	storeCachedAudio("audiohash", metadata, "wav", srcPath)
+
+	cached, ok := loadCachedAudio("audiohash")
+	if !ok {
+		t.Fatal("expected cached audio to be found")
+	}
+	if cached.InputFormat != "wav" || cached.Metadata.SampleRate != 48000 {
+		t.Errorf("round-tripped cached audio mismatch: %+v", cached)
+	}
+
+	raw, err := os.ReadFile(cachedAudioPath("audiohash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "fake-audio-bytes" {
+		t.Errorf("cached audio bytes = %q, want %q", raw, "fake-audio-bytes")
+	}
+
+	if _, ok := loadCachedAudio("missinghash"); ok {
+		t.Error("expected no cache hit for an unknown audio hash")
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,19 +13,54 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/go-audio/wav"
-	"github.com/tcolgate/mp3"
+	"github.com/kkdai/youtube/v2"
 )
 
 const (
 	MaxAudioFileSize = 150 * 1024 * 1024 // 150MB limit
 	ServerTimeout    = 30 * time.Second
+
+	// YouTube sources are transcoded to a fixed PCM format so the raw audio
+	// feeding audiowaveform is always interleaved s16le at a known rate.
+	youtubeSampleRate = 48000
+	youtubeChannels   = 2
+
+	// youtubeCacheDir holds the decoded raw PCM and metadata for previously
+	// fetched videos, keyed by video ID, so repeated waveform requests at
+	// different zoom levels don't re-download or re-transcode the source.
+	youtubeCacheDir = "cache/youtube"
+
+	// cacheDir holds content-addressed audio + rendered waveform responses,
+	// keyed by audio hash and audiowaveform parameter hash.
+	cacheDir = "cache"
 )
 
+// audiowaveformFormats lists the --input-format values audiowaveform can
+// read natively. Containers outside this set are transcoded to WAV first.
+var audiowaveformFormats = map[string]bool{
+	"wav":  true,
+	"mp3":  true,
+	"flac": true,
+	"ogg":  true,
+}
+
+// supportedContainers are the containers we'll probe, transcode if needed,
+// and hand to audiowaveform.
+var supportedContainers = map[string]bool{
+	"wav":  true,
+	"mp3":  true,
+	"flac": true,
+	"ogg":  true,
+	"opus": true,
+	"m4a":  true,
+	"webm": true,
+}
+
 // Metadata represents the metadata extracted from the audio file.
 type Metadata struct {
 	Duration   float64 `json:"duration"`
@@ -30,6 +68,29 @@ type Metadata struct {
 	Channels   int     `json:"channels"`
 	Bitrate    int     `json:"bitrate"`
 	FileSize   int64   `json:"file_size"`
+	Codec      string  `json:"codec"`
+	Container  string  `json:"container"`
+}
+
+// ffprobeOutput mirrors the JSON produced by
+// `ffprobe -show_streams -show_format -of json`.
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  ffprobeFormat   `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecType  string `json:"codec_type"`
+	CodecName  string `json:"codec_name"`
+	SampleRate string `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type ffprobeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
 }
 
 // WaveformResponse represents the successful API response.
@@ -41,16 +102,19 @@ type WaveformResponse struct {
 // APIRequest represents the JSON request body.
 type APIRequest struct {
 	AudioURL        string  `json:"audio_url"`
+	YouTubeURL      string  `json:"youtube_url"`
 	TotalPoints     int     `json:"total_points"`
 	PointsPerSecond int     `json:"points_per_second"`
 	Zoom            int     `json:"zoom"`
 	Bits            int     `json:"bits"`
 	SplitChannels   bool    `json:"split_channels"`
 	AmplitudeScale  float64 `json:"amplitude_scale"`
+	Engine          string  `json:"engine"` // "native" (default) or "audiowaveform"
 }
 
 func main() {
 	http.HandleFunc("/waveform-metadata", waveformHandler)
+	http.HandleFunc("/waveform-metadata/stream", streamHandler)
 	http.HandleFunc("/health", healthHandler)
 
 	fmt.Println("Starting server on :8080")
@@ -78,8 +142,8 @@ func waveformHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if params.AudioURL == "" {
-		http.Error(w, "Missing required 'audio_url' field", http.StatusBadRequest)
+	if params.AudioURL == "" && params.YouTubeURL == "" {
+		http.Error(w, "Missing required 'audio_url' or 'youtube_url' field", http.StatusBadRequest)
 		return
 	}
 
@@ -88,86 +152,194 @@ func waveformHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var audioData io.Reader
-	var audioType string
+	forceRefresh := r.URL.Query().Get("force_refresh") == "true"
+	paramsHash := hashParams(params)
+
+	var metadata *Metadata
+	var inputPath, inputFormat, audioHash string
 
-	if strings.HasPrefix(params.AudioURL, "data:") {
-		// Handle Base64 data URI
-		parts := strings.SplitN(params.AudioURL, ",", 2)
-		if len(parts) != 2 {
-			http.Error(w, "Invalid data URI format", http.StatusBadRequest)
+	if params.YouTubeURL != "" {
+		videoID, err := youtube.ExtractVideoID(params.YouTubeURL)
+		if err != nil {
+			http.Error(w, "Invalid YouTube URL or ID: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		audioHash = "yt:" + videoID
 
-		header := parts[0]
-		if strings.Contains(header, "audio/wav") {
-			audioType = "wav"
-		} else if strings.Contains(header, "audio/mpeg") {
-			audioType = "mp3"
-		} else {
-			http.Error(w, "Unsupported media type in data URI. Please use 'audio/wav' or 'audio/mpeg'.", http.StatusUnsupportedMediaType)
-			return
+		if !forceRefresh {
+			if cached, ok := loadCachedResponse(audioHash, paramsHash); ok {
+				writeCacheHeaders(w, audioHash, paramsHash)
+				json.NewEncoder(w).Encode(cached)
+				return
+			}
 		}
 
-		decoded, err := base64.StdEncoding.DecodeString(parts[1])
+		pcmPath, m, err := fetchYouTubeAudio(r.Context(), params.YouTubeURL)
 		if err != nil {
-			http.Error(w, "Failed to decode base64 audio data: "+err.Error(), http.StatusBadRequest)
+			http.Error(w, "Failed to fetch YouTube audio: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		audioData = io.LimitReader(bytes.NewReader(decoded), MaxAudioFileSize)
+		metadata = m
+		inputPath = pcmPath
+		inputFormat = "raw"
 	} else {
-		// Handle URL
-		lowerURL := strings.ToLower(params.AudioURL)
+		var audioData io.Reader
+
+		if strings.HasPrefix(params.AudioURL, "data:") {
+			// Handle Base64 data URI. The actual container/codec is determined
+			// later by probing the decoded bytes rather than trusting the
+			// declared mimetype.
+			parts := strings.SplitN(params.AudioURL, ",", 2)
+			if len(parts) != 2 {
+				http.Error(w, "Invalid data URI format", http.StatusBadRequest)
+				return
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				http.Error(w, "Failed to decode base64 audio data: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(decoded) > MaxAudioFileSize {
+				http.Error(w, fmt.Sprintf("Audio exceeds maximum size of %d bytes", MaxAudioFileSize), http.StatusBadRequest)
+				return
+			}
+			audioData = bytes.NewReader(decoded)
+		} else {
+			// Handle URL. validateAudioURL and the client's DialContext/
+			// CheckRedirect guard against SSRF (private/loopback/link-local/
+			// metadata addresses and DNS rebinding), not just the obvious
+			// localhost/127.0.0.1 string forms.
+			audioURL, err := validateAudioURL(r.Context(), params.AudioURL)
+			if err != nil {
+				http.Error(w, "Invalid audio URL: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, audioURL.String(), nil)
+			if err != nil {
+				http.Error(w, "Failed to build request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			client := newAudioHTTPClient(ServerTimeout)
+			resp, err := client.Do(req)
+			if err != nil {
+				http.Error(w, "Failed to fetch audio from URL: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer resp.Body.Close()
+			audioData = resp.Body
+		}
 
-		// Disallow local/private URLs
-		if strings.Contains(lowerURL, "localhost") || strings.Contains(lowerURL, "127.0.0.1") || strings.Contains(lowerURL, "10.") || strings.Contains(lowerURL, "192.168.") {
-			http.Error(w, "Local/private URLs not allowed", http.StatusBadRequest)
+		tempFile, err := os.CreateTemp("", "audio-*.tmp")
+		if err != nil {
+			http.Error(w, "Failed to create temporary file: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer os.Remove(tempFile.Name())
 
-		if strings.HasSuffix(lowerURL, ".wav") {
-			audioType = "wav"
-		} else if strings.HasSuffix(lowerURL, ".mp3") {
-			audioType = "mp3"
-		} else {
-			http.Error(w, "Unsupported audio format from URL. Please use a URL ending in '.wav' or '.mp3'.", http.StatusUnsupportedMediaType)
+		hasher := sha256.New()
+		if _, err := copyWithLimit(io.MultiWriter(tempFile, hasher), audioData, MaxAudioFileSize); err != nil {
+			http.Error(w, "Failed to save audio data: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		tempFile.Close()
+		audioHash = hex.EncodeToString(hasher.Sum(nil))
+
+		if !forceRefresh {
+			if cached, ok := loadCachedResponse(audioHash, paramsHash); ok {
+				writeCacheHeaders(w, audioHash, paramsHash)
+				json.NewEncoder(w).Encode(cached)
+				return
+			}
+		}
 
-		client := &http.Client{Timeout: ServerTimeout}
-		resp, err := client.Get(params.AudioURL)
+		if cached, ok := loadCachedAudio(audioHash); ok && !forceRefresh {
+			metadata = &cached.Metadata
+			inputPath = cachedAudioPath(audioHash)
+			inputFormat = cached.InputFormat
+		} else {
+			metadata, err = probeAudio(r.Context(), tempFile.Name())
+			if err != nil {
+				http.Error(w, "Failed to detect audio format: "+err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			if !supportedContainers[metadata.Container] {
+				http.Error(w, "Unsupported audio format: "+metadata.Container, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			inputPath = tempFile.Name()
+			inputFormat = metadata.Container
+			if !audiowaveformFormats[inputFormat] {
+				// audiowaveform can't read this container natively (m4a/aac, webm,
+				// raw opus); transcode it to WAV first.
+				wavPath, err := transcodeToWAV(r.Context(), inputPath)
+				if err != nil {
+					http.Error(w, "Failed to transcode audio: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				defer os.Remove(wavPath)
+				inputPath = wavPath
+				inputFormat = "wav"
+			}
+
+			storeCachedAudio(audioHash, *metadata, inputFormat, inputPath)
+		}
+	}
+
+	var waveformResult any
+	if resolveEngine(params) == engineAudiowaveform {
+		result, err := runAudiowaveform(r.Context(), inputPath, inputFormat, metadata, params)
 		if err != nil {
-			http.Error(w, "Failed to fetch audio from URL: "+err.Error(), http.StatusBadRequest)
+			http.Error(w, fmt.Sprintf("Failed to execute audiowaveform: %s", err), http.StatusInternalServerError)
 			return
 		}
-		defer resp.Body.Close()
-		audioData = io.LimitReader(resp.Body, MaxAudioFileSize)
-	}
+		waveformResult = result
+	} else {
+		pcmPath, err := decodeToPCM(r.Context(), inputPath, inputFormat, metadata.SampleRate, metadata.Channels)
+		if err != nil {
+			http.Error(w, "Failed to decode audio to PCM: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if pcmPath != inputPath {
+			defer os.Remove(pcmPath)
+		}
 
-	tempFile, err := os.CreateTemp("", "audio-*.tmp")
-	if err != nil {
-		http.Error(w, "Failed to create temporary file: "+err.Error(), http.StatusInternalServerError)
-		return
+		samplesPerPixel := computeSamplesPerPixel(params, metadata.SampleRate, metadata.Duration)
+		peaks, err := computePeaksNative(r.Context(), pcmPath, metadata.SampleRate, metadata.Channels, samplesPerPixel, params.Bits, params.SplitChannels, params.AmplitudeScale, nil)
+		if err != nil {
+			http.Error(w, "Failed to compute peaks: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		waveformResult = peaks
 	}
-	defer os.Remove(tempFile.Name())
 
-	if _, err := io.Copy(tempFile, audioData); err != nil {
-		http.Error(w, "Failed to save audio data: "+err.Error(), http.StatusInternalServerError)
-		return
+	response := WaveformResponse{
+		Metadata:      metadata,
+		Audiowaveform: waveformResult,
 	}
-	tempFile.Close()
+	storeCachedResponse(audioHash, paramsHash, response)
 
-	metadata, err := extractMetadata(tempFile.Name(), audioType)
-	if err != nil {
-		http.Error(w, "Failed to extract metadata: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+	writeCacheHeaders(w, audioHash, paramsHash)
+	json.NewEncoder(w).Encode(response)
+}
 
+// runAudiowaveform shells out to the audiowaveform binary, the fallback
+// engine for when the native peak computation isn't desired.
+func runAudiowaveform(ctx context.Context, inputPath, inputFormat string, metadata *Metadata, params APIRequest) (any, error) {
 	args := []string{
-		"-i", tempFile.Name(),
-		"--input-format", audioType,
+		"-i", inputPath,
+		"--input-format", inputFormat,
 		"--output-format", "json",
 	}
+	if inputFormat == "raw" {
+		args = append(args,
+			"--raw-samplerate", strconv.Itoa(metadata.SampleRate),
+			"--raw-channels", strconv.Itoa(metadata.Channels),
+			"--raw-format", "s16le",
+		)
+	}
 
 	if params.TotalPoints > 0 {
 		pps := float64(params.TotalPoints) / metadata.Duration
@@ -188,115 +360,346 @@ func waveformHandler(w http.ResponseWriter, r *http.Request) {
 		args = append(args, "--amplitude-scale", fmt.Sprintf("%.2f", params.AmplitudeScale))
 	}
 
-	cmd := exec.Command("audiowaveform", args...)
+	cmd := exec.CommandContext(ctx, "audiowaveform", args...)
 	var out, stderr bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to execute audiowaveform: %s", stderr.String()), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("%s", stderr.String())
 	}
 
-	var waveformData any
-	if err := json.Unmarshal(out.Bytes(), &waveformData); err != nil {
-		http.Error(w, "Failed to parse waveform data from tool: "+err.Error(), http.StatusInternalServerError)
-		return
+	var result any
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse waveform data from tool: %w", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(WaveformResponse{
-		Metadata:      metadata,
-		Audiowaveform: waveformData,
-	})
+	return result, nil
 }
 
-func extractMetadata(filePath string, audioType string) (*Metadata, error) {
-	file, err := os.Open(filePath)
+// probeAudio shells out to ffprobe to identify the container/codec of the
+// file at filePath and populate a Metadata from its JSON report, replacing
+// the old per-format decoders that relied on the caller already knowing the
+// audio type.
+func probeAudio(ctx context.Context, filePath string) (*Metadata, error) {
+	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, err
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", filePath)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe: %s", stderr.String())
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var stream *ffprobeStream
+	for i := range probe.Streams {
+		if probe.Streams[i].CodecType == "audio" {
+			stream = &probe.Streams[i]
+			break
+		}
+	}
+	if stream == nil {
+		return nil, fmt.Errorf("no audio stream found")
+	}
+
+	container := containerFromFormatName(probe.Format.FormatName)
+	if container == "ogg" && stream.CodecName == "opus" {
+		// A standalone Opus file is itself an Ogg container, so format_name
+		// alone can't tell it apart from Ogg/Vorbis; audiowaveform (libsndfile)
+		// can read the latter but not the former, so route by codec here
+		// rather than leaving it to falsely match "ogg" in audiowaveformFormats.
+		container = "opus"
 	}
 
 	metadata := &Metadata{
-		FileSize: fileInfo.Size(),
+		FileSize:   fileInfo.Size(),
+		SampleRate: atoiOrZero(stream.SampleRate),
+		Channels:   stream.Channels,
+		Codec:      stream.CodecName,
+		Container:  container,
 	}
+	metadata.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
 
-	file.Seek(0, 0)
+	bitRate := stream.BitRate
+	if bitRate == "" {
+		bitRate = probe.Format.BitRate
+	}
+	metadata.Bitrate = atoiOrZero(bitRate)
 
-	switch audioType {
-	case "wav":
-		d := wav.NewDecoder(file)
-		if d == nil {
-			return nil, fmt.Errorf("invalid wav file")
-		}
-		d.ReadInfo()
-		duration, err := d.Duration()
-		if err != nil {
-			return nil, err
+	return metadata, nil
+}
+
+// containerFromFormatName maps ffprobe's format_name (often a comma-separated
+// list of demuxer aliases) to the canonical container identifier used
+// elsewhere in this package.
+func containerFromFormatName(formatName string) string {
+	for _, name := range strings.Split(formatName, ",") {
+		switch name {
+		case "wav":
+			return "wav"
+		case "mp3":
+			return "mp3"
+		case "flac":
+			return "flac"
+		case "ogg":
+			return "ogg"
+		case "opus":
+			return "opus"
+		case "m4a", "mp4", "mov":
+			return "m4a"
+		case "webm", "matroska":
+			return "webm"
 		}
-		metadata.Duration = duration.Seconds()
-		metadata.SampleRate = int(d.SampleRate)
-		metadata.Channels = int(d.NumChans)
-		metadata.Bitrate = int(d.AvgBytesPerSec * 8)
-	case "mp3":
-		decoder := mp3.NewDecoder(file)
-		var frame mp3.Frame
-		var skipped int
-		var totalDuration time.Duration
-		var firstFrame = true
-
-		// Iterate through all frames to calculate duration
-		for {
-			err := decoder.Decode(&frame, &skipped)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				if !firstFrame {
-					// MP3 file may have trailing metadata, padding or corrupted frames
-					break
-				}
-				return nil, err
-			}
+	}
+	return formatName
+}
 
-			// Get metadata from the first frame
-			if firstFrame {
-				header := frame.Header()
-				metadata.SampleRate = int(header.SampleRate())
-
-				// Determine channel count from channel mode
-				channelMode := header.ChannelMode()
-				switch channelMode {
-				case mp3.SingleChannel:
-					metadata.Channels = 1
-				case mp3.Stereo, mp3.JointStereo, mp3.DualChannel:
-					metadata.Channels = 2
-				default:
-					metadata.Channels = 2
-				}
+// atoiOrZero parses s as an int, returning 0 if s is empty or not numeric
+// (ffprobe reports some fields as "N/A" or "0/0" for unknown values).
+func atoiOrZero(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
 
-				firstFrame = false
-			}
+// transcodeToWAV decodes filePath to PCM WAV via ffmpeg, for containers
+// audiowaveform can't read natively (m4a/aac, webm, raw opus).
+func transcodeToWAV(ctx context.Context, filePath string) (string, error) {
+	out, err := os.CreateTemp("", "audio-*.wav")
+	if err != nil {
+		return "", err
+	}
+	outPath := out.Name()
+	out.Close()
 
-			// Add frame duration to total
-			totalDuration += frame.Duration()
-		}
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", filePath, "-vn", outPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(outPath)
+		return "", fmt.Errorf("ffmpeg: %s", stderr.String())
+	}
+	return outPath, nil
+}
 
-		metadata.Duration = totalDuration.Seconds()
+// fetchYouTubeAudio downloads the best audio-only stream for a YouTube
+// video, transcodes it to raw interleaved s16le PCM via ffmpeg, and caches
+// the result on disk keyed by video ID so repeat requests for the same
+// video (e.g. at different zoom levels) skip the download and transcode.
+func fetchYouTubeAudio(ctx context.Context, videoURL string) (string, *Metadata, error) {
+	videoID, err := youtube.ExtractVideoID(videoURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid YouTube URL or ID: %w", err)
+	}
 
-		// Calculate average bitrate
-		if metadata.Duration > 0 {
-			metadata.Bitrate = int((metadata.FileSize * 8) / int64(metadata.Duration))
-		}
-	default:
-		return nil, fmt.Errorf("unsupported audio_type: %s", audioType)
+	if err := os.MkdirAll(youtubeCacheDir, 0o755); err != nil {
+		return "", nil, err
 	}
+	pcmPath := filepath.Join(youtubeCacheDir, videoID+".raw")
+	metaPath := filepath.Join(youtubeCacheDir, videoID+".json")
 
-	return metadata, nil
+	if metadata, err := readCachedYouTubeMetadata(metaPath, pcmPath); err == nil {
+		return pcmPath, metadata, nil
+	}
+
+	client := youtube.Client{}
+	video, err := client.GetVideo(videoID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch video info: %w", err)
+	}
+
+	formats := video.Formats.WithAudioChannels()
+	itag140 := formats.Itag(140) // m4a audio-only
+	var format *youtube.Format
+	if len(itag140) > 0 {
+		format = &itag140[0]
+	} else if len(formats) > 0 {
+		format = &formats[0]
+	} else {
+		return "", nil, fmt.Errorf("no audio-only stream available")
+	}
+
+	stream, _, err := client.GetStream(video, format)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open video stream: %w", err)
+	}
+	defer stream.Close()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-i", "pipe:0",
+		"-f", "s16le",
+		"-ar", strconv.Itoa(youtubeSampleRate),
+		"-ac", strconv.Itoa(youtubeChannels),
+		"-acodec", "pcm_s16le",
+		pcmPath,
+	)
+	cmd.Stdin = stream
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(pcmPath)
+		return "", nil, fmt.Errorf("ffmpeg: %s", stderr.String())
+	}
+
+	pcmInfo, err := os.Stat(pcmPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadata := &Metadata{
+		Duration:   video.Duration.Seconds(),
+		SampleRate: youtubeSampleRate,
+		Channels:   youtubeChannels,
+		Bitrate:    format.Bitrate,
+		FileSize:   pcmInfo.Size(),
+		Codec:      "pcm_s16le",
+		Container:  "raw",
+	}
+	if metaBytes, err := json.Marshal(metadata); err == nil {
+		os.WriteFile(metaPath, metaBytes, 0o644)
+	}
+
+	return pcmPath, metadata, nil
+}
+
+// readCachedYouTubeMetadata loads a previously cached metadata file,
+// erroring if either it or its paired raw PCM file is missing.
+func readCachedYouTubeMetadata(metaPath, pcmPath string) (*Metadata, error) {
+	if _, err := os.Stat(pcmPath); err != nil {
+		return nil, err
+	}
+	metaBytes, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(metaBytes, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// cachedAudio indexes the audio fed to audiowaveform for a given content
+// hash, so later requests at different render parameters can reuse it
+// without re-probing or re-transcoding.
+type cachedAudio struct {
+	Metadata    Metadata `json:"metadata"`
+	InputFormat string   `json:"input_format"`
+}
+
+// hashParams derives a short, stable key from the render parameters, so
+// responses for the same audio at different zoom levels land in distinct
+// cache entries. The resolved engine is included too: native and
+// audiowaveform can disagree slightly on peak values, so a request for one
+// must never be served the other's cached response.
+func hashParams(params APIRequest) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d|%d|%d|%d|%t|%.4f|%s",
+		params.TotalPoints, params.PointsPerSecond, params.Zoom,
+		params.Bits, params.SplitChannels, params.AmplitudeScale, resolveEngine(params))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func cachedAudioPath(audioHash string) string {
+	return filepath.Join(cacheDir, audioHash+".raw")
+}
+
+func cachedAudioIndexPath(audioHash string) string {
+	return filepath.Join(cacheDir, audioHash+".json")
+}
+
+func responseCachePath(audioHash, paramsHash string) string {
+	return filepath.Join(cacheDir, audioHash+"-"+paramsHash+".wf.json")
+}
+
+// loadCachedAudio returns the indexed metadata and input format for a
+// previously seen audio hash, if both the index and its raw copy exist.
+func loadCachedAudio(audioHash string) (*cachedAudio, bool) {
+	if _, err := os.Stat(cachedAudioPath(audioHash)); err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(cachedAudioIndexPath(audioHash))
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedAudio
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, false
+	}
+	return &cached, true
+}
+
+// storeCachedAudio saves a copy of the decoded audio fed to audiowaveform,
+// plus its metadata and format, under the content-addressed audioHash.
+func storeCachedAudio(audioHash string, metadata Metadata, inputFormat, inputPath string) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return
+	}
+
+	src, err := os.Open(inputPath)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.Create(cachedAudioPath(audioHash))
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(cachedAudio{Metadata: metadata, InputFormat: inputFormat})
+	if err != nil {
+		return
+	}
+	os.WriteFile(cachedAudioIndexPath(audioHash), data, 0o644)
+}
+
+// loadCachedResponse returns a previously rendered WaveformResponse for the
+// given audio/params pair, if one is cached.
+func loadCachedResponse(audioHash, paramsHash string) (*WaveformResponse, bool) {
+	data, err := os.ReadFile(responseCachePath(audioHash, paramsHash))
+	if err != nil {
+		return nil, false
+	}
+	var resp WaveformResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// storeCachedResponse saves a rendered WaveformResponse so identical
+// requests return instantly without re-invoking audiowaveform.
+func storeCachedResponse(audioHash, paramsHash string, resp WaveformResponse) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	os.WriteFile(responseCachePath(audioHash, paramsHash), data, 0o644)
+}
+
+// writeCacheHeaders sets response headers derived from the cache key so
+// clients and CDNs can validate/cache the (immutable, content-addressed)
+// waveform response.
+func writeCacheHeaders(w http.ResponseWriter, audioHash, paramsHash string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+audioHash+"-"+paramsHash+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
 }
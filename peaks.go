@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// Engines for peak computation. Native decodes audio in-process; the
+// audiowaveform binary remains available as a fallback via the request's
+// "engine" field or the WAVEFORM_ENGINE env var.
+const (
+	engineNative        = "native"
+	engineAudiowaveform = "audiowaveform"
+
+	defaultSamplesPerPixel = 256
+
+	// streamChunkBuckets is how many peak buckets accumulate between
+	// progress callbacks when streaming (see streamHandler).
+	streamChunkBuckets = 200
+)
+
+// waveformPeaks mirrors audiowaveform's JSON schema so native and
+// audiowaveform-backed responses are interchangeable to API consumers.
+type waveformPeaks struct {
+	Version         int   `json:"version"`
+	Channels        int   `json:"channels"`
+	SampleRate      int   `json:"sample_rate"`
+	SamplesPerPixel int   `json:"samples_per_pixel"`
+	Bits            int   `json:"bits"`
+	Length          int   `json:"length"`
+	Data            []int `json:"data"`
+}
+
+// resolveEngine decides which peak-generation engine to use for a request:
+// an explicit "engine" field wins, then the WAVEFORM_ENGINE env var,
+// defaulting to the native in-process implementation.
+func resolveEngine(params APIRequest) string {
+	if params.Engine != "" {
+		return params.Engine
+	}
+	if env := os.Getenv("WAVEFORM_ENGINE"); env != "" {
+		return env
+	}
+	return engineNative
+}
+
+// computeSamplesPerPixel derives audiowaveform's samples-per-pixel value
+// from the same request fields the audiowaveform-backed path uses.
+func computeSamplesPerPixel(params APIRequest, sampleRate int, duration float64) int {
+	switch {
+	case params.TotalPoints > 0 && duration > 0:
+		pointsPerSecond := float64(params.TotalPoints) / duration
+		if pointsPerSecond <= 0 {
+			return defaultSamplesPerPixel
+		}
+		spp := int(float64(sampleRate) / pointsPerSecond)
+		if spp < 1 {
+			spp = 1
+		}
+		return spp
+	case params.PointsPerSecond > 0:
+		spp := sampleRate / params.PointsPerSecond
+		if spp < 1 {
+			spp = 1
+		}
+		return spp
+	case params.Zoom > 0:
+		return params.Zoom
+	default:
+		return defaultSamplesPerPixel
+	}
+}
+
+// decodeToPCM transcodes inputPath to raw interleaved s16le PCM at the
+// given sample rate/channel count via ffmpeg, so computePeaksNative always
+// works from a known, simple layout. Inputs already in that layout (the
+// YouTube source path) are returned unchanged.
+func decodeToPCM(ctx context.Context, inputPath, inputFormat string, sampleRate, channels int) (string, error) {
+	if inputFormat == "raw" {
+		return inputPath, nil
+	}
+
+	out, err := os.CreateTemp("", "pcm-*.raw")
+	if err != nil {
+		return "", err
+	}
+	outPath := out.Name()
+	out.Close()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-i", inputPath,
+		"-f", "s16le",
+		"-ar", strconv.Itoa(sampleRate),
+		"-ac", strconv.Itoa(channels),
+		"-acodec", "pcm_s16le",
+		outPath,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(outPath)
+		return "", fmt.Errorf("ffmpeg: %s", stderr.String())
+	}
+	return outPath, nil
+}
+
+// computePeaksNative reads raw interleaved s16le PCM and computes
+// min/max peak pairs per bucket of samplesPerPixel frames, optionally per
+// channel, mirroring audiowaveform's own peak-generation algorithm.
+//
+// If onChunk is non-nil, it's called every streamChunkBuckets buckets with
+// the peak data accumulated since the last call (offset is the starting
+// index into the overall Data slice), letting callers stream partial
+// results; returning an error or ctx being canceled aborts early.
+func computePeaksNative(ctx context.Context, pcmPath string, sampleRate, channels, samplesPerPixel, bits int, splitChannels bool, amplitudeScale float64, onChunk func(offset int, data []int) error) (*waveformPeaks, error) {
+	if bits != 8 {
+		bits = 16
+	}
+	if amplitudeScale <= 0 {
+		amplitudeScale = 1
+	}
+	if samplesPerPixel < 1 {
+		samplesPerPixel = defaultSamplesPerPixel
+	}
+
+	f, err := os.Open(pcmPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	outChannels := 1
+	if splitChannels {
+		outChannels = channels
+	}
+
+	mins := make([]int32, outChannels)
+	maxes := make([]int32, outChannels)
+	resetBucket := func() {
+		for i := range mins {
+			mins[i] = math.MaxInt32
+			maxes[i] = math.MinInt32
+		}
+	}
+	resetBucket()
+
+	var data []int
+	chunkStart := 0
+	bucketsSinceChunk := 0
+	frame := make([]int16, channels)
+	reader := bufio.NewReaderSize(f, 64*1024)
+
+	framesInBucket := 0
+	length := 0
+
+	flush := func() error {
+		for i := 0; i < outChannels; i++ {
+			data = append(data, scalePeak(mins[i], amplitudeScale, bits), scalePeak(maxes[i], amplitudeScale, bits))
+		}
+		length++
+		resetBucket()
+		framesInBucket = 0
+
+		bucketsSinceChunk++
+		if onChunk != nil && bucketsSinceChunk >= streamChunkBuckets {
+			if err := onChunk(chunkStart, data[chunkStart:]); err != nil {
+				return err
+			}
+			chunkStart = len(data)
+			bucketsSinceChunk = 0
+		}
+		return nil
+	}
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		if err := binary.Read(reader, binary.LittleEndian, &frame); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break loop
+			}
+			return nil, err
+		}
+
+		for ch, s := range frame {
+			bucket := 0
+			if splitChannels {
+				bucket = ch
+			}
+			v := int32(s)
+			if v < mins[bucket] {
+				mins[bucket] = v
+			}
+			if v > maxes[bucket] {
+				maxes[bucket] = v
+			}
+		}
+
+		framesInBucket++
+		if framesInBucket >= samplesPerPixel {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if framesInBucket > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	if onChunk != nil && chunkStart < len(data) {
+		if err := onChunk(chunkStart, data[chunkStart:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &waveformPeaks{
+		Version:         2,
+		Channels:        outChannels,
+		SampleRate:      sampleRate,
+		SamplesPerPixel: samplesPerPixel,
+		Bits:            bits,
+		Length:          length,
+		Data:            data,
+	}, nil
+}
+
+// scalePeak rescales a peak sample by amplitudeScale and clamps it to the
+// target bit depth's range, matching audiowaveform's --amplitude-scale and
+// --bits behavior.
+func scalePeak(v int32, amplitudeScale float64, bits int) int {
+	scaled := float64(v) * amplitudeScale
+	if bits == 8 {
+		scaled /= 256
+		return int(clamp(scaled, -128, 127))
+	}
+	return int(clamp(scaled, -32768, 32767))
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
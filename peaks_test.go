@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeSamplesPerPixel(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     APIRequest
+		sampleRate int
+		duration   float64
+		want       int
+	}{
+		{"total points derives from duration", APIRequest{TotalPoints: 100}, 44100, 10, 4410},
+		{"total points rounds down, floors at 1", APIRequest{TotalPoints: 1_000_000}, 44100, 10, 1},
+		{"total points ignored without duration", APIRequest{TotalPoints: 100}, 44100, 0, defaultSamplesPerPixel},
+		{"points per second derives directly", APIRequest{PointsPerSecond: 100}, 44100, 0, 441},
+		{"points per second floors at 1", APIRequest{PointsPerSecond: 1_000_000}, 44100, 0, 1},
+		{"zoom passes through unchanged", APIRequest{Zoom: 512}, 44100, 0, 512},
+		{"defaults when nothing is set", APIRequest{}, 44100, 10, defaultSamplesPerPixel},
+		{"total points wins over zoom", APIRequest{TotalPoints: 100, Zoom: 512}, 44100, 10, 4410},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := computeSamplesPerPixel(tc.params, tc.sampleRate, tc.duration)
+			if got != tc.want {
+				t.Errorf("computeSamplesPerPixel(%+v, %d, %v) = %d, want %d", tc.params, tc.sampleRate, tc.duration, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScalePeakClamping(t *testing.T) {
+	tests := []struct {
+		name  string
+		v     int32
+		scale float64
+		bits  int
+		want  int
+	}{
+		{"16-bit passthrough", 1000, 1, 16, 1000},
+		{"16-bit max clamps at int16 max", 32767, 4, 16, 32767},
+		{"16-bit min clamps at int16 min", -32768, 4, 16, -32768},
+		{"8-bit scales down by 256", 256, 1, 8, 1},
+		{"8-bit clamps at int8 max", 32767, 1, 8, 127},
+		{"8-bit clamps at int8 min", -32768, 1, 8, -128},
+		{"amplitude scale amplifies", 100, 2, 16, 200},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := scalePeak(tc.v, tc.scale, tc.bits)
+			if got != tc.want {
+				t.Errorf("scalePeak(%d, %v, %d) = %d, want %d", tc.v, tc.scale, tc.bits, got, tc.want)
+			}
+		})
+	}
+}
+
+// writePCM writes interleaved int16 frames (one []int16 per frame, frames
+// in order) as little-endian raw PCM to a temp file and returns its path.
+func writePCM(t *testing.T, frames [][]int16) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.raw")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, frame := range frames {
+		if err := binary.Write(f, binary.LittleEndian, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestComputePeaksNativeBucketBoundaries(t *testing.T) {
+	// Mono, samplesPerPixel=4: 10 frames makes two full buckets of 4 plus a
+	// trailing partial bucket of 2 - the off-by-one case a <= vs < mistake
+	// in the flush condition would get wrong.
+	samples := []int16{1, -2, 3, -4, 5, -6, 7, -8, 9, -10}
+	frames := make([][]int16, len(samples))
+	for i, s := range samples {
+		frames[i] = []int16{s}
+	}
+	path := writePCM(t, frames)
+
+	peaks, err := computePeaksNative(context.Background(), path, 44100, 1, 4, 16, false, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if peaks.Length != 3 {
+		t.Fatalf("Length = %d, want 3 (2 full buckets + 1 partial)", peaks.Length)
+	}
+	want := []int{-4, 3, -8, 7, -10, 9}
+	if len(peaks.Data) != len(want) {
+		t.Fatalf("Data = %v, want %v", peaks.Data, want)
+	}
+	for i := range want {
+		if peaks.Data[i] != want[i] {
+			t.Errorf("Data[%d] = %d, want %d (full Data = %v)", i, peaks.Data[i], want[i], peaks.Data)
+		}
+	}
+}
+
+func TestComputePeaksNativeSplitChannels(t *testing.T) {
+	// Stereo, one bucket covering all 3 frames, split by channel.
+	frames := [][]int16{
+		{10, -1},
+		{-20, 5},
+		{15, -3},
+	}
+	path := writePCM(t, frames)
+
+	peaks, err := computePeaksNative(context.Background(), path, 44100, 2, 10, 16, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peaks.Channels != 2 {
+		t.Fatalf("Channels = %d, want 2", peaks.Channels)
+	}
+	// min0, max0, min1, max1
+	want := []int{-20, 15, -3, 5}
+	if len(peaks.Data) != len(want) {
+		t.Fatalf("Data = %v, want %v", peaks.Data, want)
+	}
+	for i := range want {
+		if peaks.Data[i] != want[i] {
+			t.Errorf("Data[%d] = %d, want %d (full Data = %v)", i, peaks.Data[i], want[i], peaks.Data)
+		}
+	}
+}
+
+func TestComputePeaksNativeStreamsChunksWithoutGaps(t *testing.T) {
+	// samplesPerPixel=1 so each frame is its own bucket; 2*streamChunkBuckets+1
+	// buckets forces two onChunk calls plus a final flush of the remainder,
+	// exercising the streaming path chunk0-5 added on top of this.
+	totalBuckets := 2*streamChunkBuckets + 1
+	frames := make([][]int16, totalBuckets)
+	for i := range frames {
+		frames[i] = []int16{int16(i)}
+	}
+	path := writePCM(t, frames)
+
+	var chunks [][]int
+	var offsets []int
+	peaks, err := computePeaksNative(context.Background(), path, 44100, 1, 1, 16, false, 1,
+		func(offset int, data []int) error {
+			offsets = append(offsets, offset)
+			chunks = append(chunks, append([]int(nil), data...))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != 3 {
+		t.Fatalf("got %d onChunk calls, want 3 (2 full + 1 trailing remainder)", len(chunks))
+	}
+
+	var reassembled []int
+	for i, c := range chunks {
+		if offsets[i] != len(reassembled) {
+			t.Errorf("chunk %d offset = %d, want %d", i, offsets[i], len(reassembled))
+		}
+		reassembled = append(reassembled, c...)
+	}
+	if len(reassembled) != len(peaks.Data) {
+		t.Fatalf("reassembled chunks have %d entries, want %d", len(reassembled), len(peaks.Data))
+	}
+	for i := range peaks.Data {
+		if reassembled[i] != peaks.Data[i] {
+			t.Errorf("reassembled[%d] = %d, want %d", i, reassembled[i], peaks.Data[i])
+		}
+	}
+}
+
+func TestComputePeaksNativeContextCancellation(t *testing.T) {
+	frames := [][]int16{{1}, {2}, {3}}
+	path := writePCM(t, frames)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := computePeaksNative(ctx, path, 44100, 1, 1, 16, false, 1, nil)
+	if err == nil {
+		t.Fatal("expected a canceled context to abort computePeaksNative with an error")
+	}
+}
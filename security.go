@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// allowedAudioPorts are the ports validateAudioURL permits for outbound
+// audio fetches. Anything else (e.g. the metadata service's 80/tcp on a
+// non-standard port, or a scanned-open internal port) is rejected.
+var allowedAudioPorts = map[string]bool{"80": true, "443": true}
+
+// blockedAudioNetworks are the address ranges validateAudioURL and
+// auditedDialContext refuse to connect to: loopback, RFC1918 and
+// link-local space, IPv6 ULA, and the AWS/GCP metadata endpoints.
+var blockedAudioNetworks = mustParseCIDRs(
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"169.254.169.254/32",
+	"fd00:ec2::254/128",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// isBlockedAudioIP reports whether ip falls in a range audio URLs must not
+// resolve or dial to.
+func isBlockedAudioIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return true
+	}
+	for _, n := range blockedAudioNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAudioURL parses rawURL, enforces an http/https scheme and an
+// allowed port, and resolves the hostname to confirm none of its addresses
+// fall in a blocked range. It's the first line of defense against SSRF;
+// auditedDialContext repeats the check at connection time to close the
+// DNS-rebinding gap between this lookup and the actual dial.
+func validateAudioURL(ctx context.Context, rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	port := u.Port()
+	if port == "" {
+		if u.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	if !allowedAudioPorts[port] {
+		return nil, fmt.Errorf("port %s not allowed", port)
+	}
+
+	if err := resolveAndCheckHost(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// resolveAndCheckHost resolves host (or parses it as a literal IP) and
+// rejects it if any resulting address is in a blocked range.
+func resolveAndCheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedAudioIP(ip) {
+			return fmt.Errorf("host %s resolves to a blocked address", host)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve host: %w", err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("host %s has no addresses", host)
+	}
+	for _, a := range addrs {
+		if isBlockedAudioIP(a.IP) {
+			return fmt.Errorf("host %s resolves to a blocked address", host)
+		}
+	}
+	return nil
+}
+
+// newAudioHTTPClient builds an http.Client for fetching remote audio that
+// re-validates the destination at every hop: auditedDialContext resolves
+// and checks the address itself (instead of trusting net.Dial to resolve
+// the same, already-validated IP again, which a DNS-rebinding attacker
+// could answer differently) and CheckRedirect re-runs validateAudioURL on
+// every redirect target.
+func newAudioHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: auditedDialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("too many redirects")
+			}
+			_, err := validateAudioURL(req.Context(), req.URL.String())
+			return err
+		},
+	}
+}
+
+// auditedDialContext resolves addr itself and dials the first address that
+// isn't blocked, rather than handing the hostname to net.Dial and letting
+// it resolve independently of the check already performed.
+func auditedDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedAudioIP(ip) {
+			return nil, fmt.Errorf("refusing to dial blocked address %s", ip)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if isBlockedAudioIP(a.IP) {
+			continue
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(a.IP.String(), port))
+	}
+	return nil, fmt.Errorf("host %s has no permitted addresses", host)
+}
+
+// copyWithLimit copies src to dst and fails once more than limit bytes
+// have been read, instead of silently truncating at limit like
+// io.LimitReader would.
+func copyWithLimit(dst io.Writer, src io.Reader, limit int64) (int64, error) {
+	n, err := io.CopyN(dst, src, limit+1)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	if n > limit {
+		return n, fmt.Errorf("audio exceeds maximum size of %d bytes", limit)
+	}
+	return n, nil
+}
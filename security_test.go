@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsBlockedAudioIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},                           // loopback
+		{"::1", true},                                 // IPv6 loopback
+		{"::ffff:127.0.0.1", true},                    // IPv4-mapped IPv6 loopback
+		{"10.0.0.5", true},                            // RFC1918
+		{"172.16.0.1", true},                          // RFC1918
+		{"192.168.1.1", true},                         // RFC1918
+		{"169.254.169.254", true},                     // cloud metadata (AWS/GCP)
+		{"169.254.1.1", true},                         // link-local
+		{"fe80::1", true},                             // IPv6 link-local
+		{"fc00::1", true},                             // IPv6 ULA
+		{"fd00:ec2::254", true},                       // AWS IMDSv2 IPv6 endpoint
+		{"8.8.8.8", false},                            // public
+		{"93.184.216.34", false},                      // public
+		{"2606:2800:220:1:248:1893:25c8:1946", false}, // public IPv6
+	}
+
+	for _, tc := range tests {
+		ip := net.ParseIP(tc.ip)
+		if ip == nil {
+			t.Fatalf("test setup: %q did not parse as an IP", tc.ip)
+		}
+		if got := isBlockedAudioIP(ip); got != tc.blocked {
+			t.Errorf("isBlockedAudioIP(%s) = %v, want %v", tc.ip, got, tc.blocked)
+		}
+	}
+}
+
+func TestValidateAudioURLRejectsBypassForms(t *testing.T) {
+	// Each of these mirrors a concrete SSRF bypass the naive
+	// strings.Contains(localhost/127.0.0.1/...) check used to miss.
+	urls := []string{
+		"http://LOCALHOST/audio.wav",      // case variation
+		"http://127.0.0.1/audio.wav",      // loopback literal
+		"http://[::1]/audio.wav",          // IPv6 loopback literal
+		"http://169.254.169.254/latest/",  // cloud metadata endpoint
+		"http://2130706433/audio.wav",     // decimal form of 127.0.0.1
+		"ftp://example.com/audio.wav",     // disallowed scheme
+		"http://127.0.0.1:8081/audio.wav", // disallowed port
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, u := range urls {
+		if _, err := validateAudioURL(ctx, u); err == nil {
+			t.Errorf("validateAudioURL(%q) = nil error, want rejection", u)
+		}
+	}
+}
+
+func TestValidateAudioURLAllowsPublicLiterals(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, u := range []string{"http://8.8.8.8/audio.wav", "https://8.8.8.8:443/audio.wav"} {
+		if _, err := validateAudioURL(ctx, u); err != nil {
+			t.Errorf("validateAudioURL(%q) = %v, want no error", u, err)
+		}
+	}
+}
+
+func TestAuditedDialContextRejectsBlockedAddresses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, addr := range []string{"127.0.0.1:80", "localhost:80", "169.254.169.254:80"} {
+		if _, err := auditedDialContext(ctx, "tcp", addr); err == nil {
+			t.Errorf("auditedDialContext(%q) = nil error, want rejection", addr)
+		}
+	}
+}
+
+// TestNewAudioHTTPClientRevalidatesRedirects guards the DNS-rebinding-style
+// gap where a first request to an allowed host redirects to a blocked one:
+// CheckRedirect must re-run validateAudioURL on every hop, not just the
+// initial URL.
+func TestNewAudioHTTPClientRevalidatesRedirects(t *testing.T) {
+	client := newAudioHTTPClient(5 * time.Second)
+
+	req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/", nil)
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	if err := client.CheckRedirect(req, nil); err == nil {
+		t.Fatal("CheckRedirect allowed a redirect to a blocked address")
+	}
+}
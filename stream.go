@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamUpgrader upgrades the streaming endpoint's connections. Like the
+// rest of this API, it doesn't enforce an origin allowlist.
+var streamUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamMessage is the envelope pushed to clients of
+// /waveform-metadata/stream as work progresses.
+type streamMessage struct {
+	Type     string    `json:"type"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Total    int64     `json:"total,omitempty"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+	Offset   int       `json:"offset,omitempty"`
+	Data     any       `json:"data,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// streamHandler renders a waveform incrementally over a WebSocket:
+// download progress, then metadata, then peaks in chunks, then a final
+// "done" message. Closing the socket cancels the in-flight ffmpeg/
+// audiowaveform child process and cleans up temp files, the same as a
+// request timing out.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	var params APIRequest
+	if err := conn.ReadJSON(&params); err != nil {
+		conn.WriteJSON(streamMessage{Type: "error", Error: "failed to decode request: " + err.Error()})
+		return
+	}
+	if params.AudioURL == "" && params.YouTubeURL == "" {
+		conn.WriteJSON(streamMessage{Type: "error", Error: "missing required 'audio_url' or 'youtube_url' field"})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Closing the socket (or any other read error) cancels ctx, which kills
+	// whatever ffmpeg/audiowaveform child process is running via
+	// exec.CommandContext and unblocks the streaming peak computation.
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	var writeMu sync.Mutex
+	send := func(msg streamMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(msg)
+	}
+
+	inputPath, inputFormat, metadata, cleanup, err := fetchStreamSource(ctx, params, send)
+	defer cleanup()
+	if err != nil {
+		send(streamMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	if err := send(streamMessage{Type: "metadata", Metadata: metadata}); err != nil {
+		return
+	}
+
+	// The audiowaveform engine isn't incremental, so it can't stream peak
+	// chunks the way the native engine does: run it to completion and push
+	// the whole result as a single "peaks" message instead.
+	if resolveEngine(params) == engineAudiowaveform {
+		result, err := runAudiowaveform(ctx, inputPath, inputFormat, metadata, params)
+		if err != nil {
+			send(streamMessage{Type: "error", Error: fmt.Sprintf("failed to execute audiowaveform: %s", err)})
+			return
+		}
+		if err := send(streamMessage{Type: "peaks", Data: result}); err != nil {
+			return
+		}
+		send(streamMessage{Type: "done"})
+		return
+	}
+
+	pcmPath, err := decodeToPCM(ctx, inputPath, inputFormat, metadata.SampleRate, metadata.Channels)
+	if err != nil {
+		send(streamMessage{Type: "error", Error: "failed to decode audio to PCM: " + err.Error()})
+		return
+	}
+	if pcmPath != inputPath {
+		defer os.Remove(pcmPath)
+	}
+
+	samplesPerPixel := computeSamplesPerPixel(params, metadata.SampleRate, metadata.Duration)
+	_, err = computePeaksNative(ctx, pcmPath, metadata.SampleRate, metadata.Channels, samplesPerPixel, params.Bits, params.SplitChannels, params.AmplitudeScale,
+		func(offset int, data []int) error {
+			return send(streamMessage{Type: "peaks", Offset: offset, Data: data})
+		})
+	if err != nil {
+		send(streamMessage{Type: "error", Error: "failed to compute peaks: " + err.Error()})
+		return
+	}
+
+	send(streamMessage{Type: "done"})
+}
+
+// fetchStreamSource resolves an APIRequest's audio source the same way
+// waveformHandler does, but reports download progress over the socket and
+// returns a cleanup func for any temp files it created instead of relying
+// on handler-scoped defers.
+func fetchStreamSource(ctx context.Context, params APIRequest, send func(streamMessage) error) (inputPath, inputFormat string, metadata *Metadata, cleanup func(), err error) {
+	cleanup = func() {}
+
+	if params.YouTubeURL != "" {
+		send(streamMessage{Type: "download", Bytes: 0, Total: 0})
+		pcmPath, m, err := fetchYouTubeAudio(ctx, params.YouTubeURL)
+		if err != nil {
+			return "", "", nil, cleanup, err
+		}
+		return pcmPath, "raw", m, cleanup, nil
+	}
+
+	// validateAudioURL and the client's DialContext/CheckRedirect guard
+	// against SSRF, matching waveformHandler's check.
+	audioURL, err := validateAudioURL(ctx, params.AudioURL)
+	if err != nil {
+		return "", "", nil, cleanup, fmt.Errorf("invalid audio URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, audioURL.String(), nil)
+	if err != nil {
+		return "", "", nil, cleanup, err
+	}
+	client := newAudioHTTPClient(ServerTimeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", nil, cleanup, err
+	}
+	defer resp.Body.Close()
+
+	tempFile, err := os.CreateTemp("", "audio-*.tmp")
+	if err != nil {
+		return "", "", nil, cleanup, err
+	}
+	cleanup = func() { tempFile.Close(); os.Remove(tempFile.Name()) }
+
+	total := resp.ContentLength
+	var copied int64
+	buf := make([]byte, 64*1024)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", "", nil, cleanup, ctx.Err()
+		default:
+		}
+
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			copied += int64(n)
+			if copied > MaxAudioFileSize {
+				return "", "", nil, cleanup, fmt.Errorf("audio exceeds maximum size of %d bytes", MaxAudioFileSize)
+			}
+			if _, writeErr := tempFile.Write(buf[:n]); writeErr != nil {
+				return "", "", nil, cleanup, writeErr
+			}
+			if err := send(streamMessage{Type: "download", Bytes: copied, Total: total}); err != nil {
+				return "", "", nil, cleanup, err
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return "", "", nil, cleanup, readErr
+		}
+	}
+	tempFile.Close()
+
+	metadata, err = probeAudio(ctx, tempFile.Name())
+	if err != nil {
+		return "", "", nil, cleanup, err
+	}
+	if !supportedContainers[metadata.Container] {
+		return "", "", nil, cleanup, fmt.Errorf("unsupported audio format: %s", metadata.Container)
+	}
+
+	inputPath = tempFile.Name()
+	inputFormat = metadata.Container
+	if !audiowaveformFormats[inputFormat] {
+		wavPath, err := transcodeToWAV(ctx, inputPath)
+		if err != nil {
+			return "", "", nil, cleanup, err
+		}
+		prevCleanup := cleanup
+		cleanup = func() {
+			prevCleanup()
+			os.Remove(wavPath)
+		}
+		inputPath = wavPath
+		inputFormat = "wav"
+	}
+
+	return inputPath, inputFormat, metadata, cleanup, nil
+}